@@ -0,0 +1,111 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+)
+
+// backendDocker and backendPodman are the recognised values of the
+// DRONE_RUNNER_BACKEND environment variable.
+const (
+	backendDocker = "docker"
+	backendPodman = "podman"
+)
+
+// default socket paths used to auto-detect the backend when
+// DRONE_RUNNER_BACKEND is not set.
+const (
+	dockerSocketPath = "/var/run/docker.sock"
+	podmanSocketPath = "/run/podman/podman.sock"
+)
+
+// backend abstracts the container runtime operations required to run
+// a pipeline step. It allows the orchestration logic in engine_impl.go
+// to be shared by more than one runtime implementation (Docker,
+// Podman, ...).
+type backend interface {
+	// networkCreate creates the pipeline's shared network.
+	networkCreate(ctx context.Context, spec *Spec) error
+
+	// networkRemove removes the network created by networkCreate.
+	networkRemove(ctx context.Context, spec *Spec)
+
+	// volumeCreate creates a temporary volume mounted into each step.
+	volumeCreate(ctx context.Context, vol *Volume) error
+
+	// volumeRemove removes the volume created by volumeCreate.
+	volumeRemove(ctx context.Context, vol *Volume)
+
+	// imagePull pulls the step image and returns the raw JSON message
+	// stream reported by the daemon.
+	imagePull(ctx context.Context, step *Step) (io.ReadCloser, error)
+
+	// imagePullErrNotFound reports whether err, returned from
+	// containerCreate, indicates the image does not exist locally and
+	// should be pulled.
+	imagePullErrNotFound(err error) bool
+
+	// containerCreate creates (but does not start) the step container.
+	containerCreate(ctx context.Context, spec *Spec, step *Step) error
+
+	// containerStart starts a previously created container.
+	containerStart(ctx context.Context, id string) error
+
+	// containerWait blocks until the container stops and returns its
+	// final state.
+	containerWait(ctx context.Context, id string) (*State, error)
+
+	// containerTail streams the container logs to output until the
+	// container stops.
+	containerTail(ctx context.Context, id string, output io.Writer) error
+
+	// containerKill force-stops a running container.
+	containerKill(ctx context.Context, id string)
+
+	// containerRemove removes a stopped container.
+	containerRemove(ctx context.Context, id string)
+}
+
+// newBackend selects and constructs a backend based on the
+// DRONE_RUNNER_BACKEND environment variable, falling back to
+// auto-detection when it is unset.
+func newBackend() (backend, error) {
+	switch os.Getenv("DRONE_RUNNER_BACKEND") {
+	case backendPodman:
+		return newPodmanBackend(podmanSocketPath)
+	case backendDocker:
+		return newDockerBackend()
+	}
+
+	switch {
+	case dockerIsAvailable():
+		return newDockerBackend()
+	case podmanIsAvailable(podmanSocketPath):
+		return newPodmanBackend(podmanSocketPath)
+	default:
+		return nil, fmt.Errorf("engine: no container runtime found, set DRONE_RUNNER_BACKEND")
+	}
+}
+
+// dockerIsAvailable reports whether a Docker daemon can plausibly be
+// reached, either through DOCKER_HOST or the default unix socket.
+func dockerIsAvailable() bool {
+	if os.Getenv("DOCKER_HOST") != "" {
+		return true
+	}
+	_, err := os.Stat(dockerSocketPath)
+	return err == nil
+}
+
+// podmanIsAvailable reports whether the Podman REST service is
+// listening on sock.
+func podmanIsAvailable(sock string) bool {
+	_, err := os.Stat(sock)
+	return err == nil
+}
@@ -0,0 +1,250 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/drone-runners/drone-runner-docker/engine/stdcopy"
+	"github.com/drone/drone-runtime/engine/docker/auth"
+
+	"docker.io/go-docker"
+	"docker.io/go-docker/api/types"
+	"docker.io/go-docker/api/types/container"
+	"docker.io/go-docker/api/types/network"
+	"docker.io/go-docker/api/types/volume"
+)
+
+// dockerBackend implements backend using the Docker daemon API.
+type dockerBackend struct {
+	client docker.APIClient
+
+	mu     sync.Mutex
+	events map[string]*eventStream
+}
+
+// newDockerBackend returns a backend that talks to the Docker daemon
+// configured in the environment (DOCKER_HOST, DOCKER_CERT_PATH, ...).
+func newDockerBackend() (backend, error) {
+	cli, err := docker.NewEnvClient()
+	if err != nil {
+		return nil, err
+	}
+	return &dockerBackend{client: cli}, nil
+}
+
+func (b *dockerBackend) networkCreate(ctx context.Context, spec *Spec) error {
+	driver := "bridge"
+	if spec.Platform.OS == "windows" {
+		driver = "nat"
+	}
+	_, err := b.client.NetworkCreate(ctx, spec.Network.ID, types.NetworkCreate{
+		Driver:     driver,
+		EnableIPv6: spec.Network.EnableIPv6,
+		Labels:     spec.Network.Labels,
+	})
+	if err != nil {
+		return err
+	}
+
+	// create any additional user-defined networks the pipeline asked
+	// for, e.g. a dedicated network shared only by a couple of steps.
+	for _, extra := range spec.ExtraNetworks {
+		if _, err := b.client.NetworkCreate(ctx, extra.ID, toNetworkCreate(extra)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *dockerBackend) networkRemove(ctx context.Context, spec *Spec) {
+	for _, extra := range spec.ExtraNetworks {
+		b.client.NetworkRemove(ctx, extra.ID)
+	}
+	b.client.NetworkRemove(ctx, spec.Network.ID)
+}
+
+// toNetworkCreate translates a NetworkConfig into the options needed
+// to create one of the pipeline's extra networks.
+func toNetworkCreate(cfg *NetworkConfig) types.NetworkCreate {
+	create := types.NetworkCreate{
+		Driver:     cfg.Driver,
+		EnableIPv6: cfg.EnableIPv6,
+		Labels:     cfg.Labels,
+	}
+	if cfg.Subnet != "" {
+		create.IPAM = &network.IPAM{
+			Config: []network.IPAMConfig{
+				{Subnet: cfg.Subnet, Gateway: cfg.Gateway},
+			},
+		}
+	}
+	return create
+}
+
+func (b *dockerBackend) volumeCreate(ctx context.Context, vol *Volume) error {
+	// default to the local driver unless the pipeline requests a
+	// different volume driver (e.g. nfs, rexray).
+	driver := vol.EmptyDir.Driver
+	if driver == "" {
+		driver = "local"
+	}
+	_, err := b.client.VolumeCreate(ctx, volume.VolumesCreateBody{
+		Name:       vol.EmptyDir.ID,
+		Driver:     driver,
+		DriverOpts: vol.EmptyDir.DriverOpts,
+		Labels:     vol.EmptyDir.Labels,
+	})
+	return err
+}
+
+func (b *dockerBackend) volumeRemove(ctx context.Context, vol *Volume) {
+	b.client.VolumeRemove(ctx, vol.EmptyDir.ID, true)
+}
+
+func (b *dockerBackend) imagePull(ctx context.Context, step *Step) (io.ReadCloser, error) {
+	return b.client.ImagePull(ctx, step.Image, pullOptions(step))
+}
+
+func (b *dockerBackend) imagePullErrNotFound(err error) bool {
+	return docker.IsErrImageNotFound(err)
+}
+
+// pullOptions builds the image pull options, encoding the step's
+// registry credentials when present.
+func pullOptions(step *Step) types.ImagePullOptions {
+	opts := types.ImagePullOptions{}
+	if step.Auth != nil {
+		opts.RegistryAuth = auth.Encode(
+			step.Auth.Username,
+			step.Auth.Password,
+		)
+	}
+	return opts
+}
+
+func (b *dockerBackend) containerCreate(ctx context.Context, spec *Spec, step *Step) error {
+	return createContainer(ctx, b.client, spec, step, toHostConfig(spec, step))
+}
+
+// createContainer creates the step container with the given host
+// config and, on success, attaches it to any additional user-defined
+// networks it declared. It is shared by dockerBackend and
+// podmanBackend so only the host-config translation differs between
+// them, not the network-attach behaviour.
+func createContainer(ctx context.Context, client docker.APIClient, spec *Spec, step *Step, hostConfig *container.HostConfig) error {
+	_, err := client.ContainerCreate(ctx,
+		toConfig(spec, step),
+		hostConfig,
+		toNetConfig(spec, step),
+		step.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	// use the default user-defined network if network_mode is not
+	// otherwise specified (host/container:x/none reject NetworkConnect).
+	if step.Network != "" {
+		return nil
+	}
+
+	// attach the step to any additional user-defined networks it
+	// declared, giving it a stable DNS name on each so adjacent steps
+	// can reach it (e.g. a `postgres` service reachable as `db`).
+	for _, net := range step.Networks {
+		endpoint := &network.EndpointSettings{
+			Aliases: step.NetworkAliases[net],
+		}
+		if err := client.NetworkConnect(ctx, net, step.ID, endpoint); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// containerStart starts the container, first subscribing to its
+// container events so containerWait cannot miss a die/oom/health
+// event that fires between ContainerStart returning and the caller
+// asking to wait.
+func (b *dockerBackend) containerStart(ctx context.Context, id string) error {
+	b.subscribeEvents(id)
+	err := b.client.ContainerStart(ctx, id, types.ContainerStartOptions{})
+	if err != nil {
+		// the container never started, so containerWait will never be
+		// called to claim and cancel the subscription opened above;
+		// tear it down here instead of leaking the open connection.
+		if stream := b.takeEventStream(id); stream != nil {
+			stream.cancel()
+		}
+	}
+	return err
+}
+
+// containerWait blocks until the container stops, driving state
+// transitions off the event stream opened in containerStart. If the
+// event stream drops it falls back to polling ContainerInspect with
+// backoff until the container is no longer running.
+func (b *dockerBackend) containerWait(ctx context.Context, id string) (*State, error) {
+	state := &State{}
+
+	stream := b.takeEventStream(id)
+	if stream != nil {
+		defer stream.cancel()
+		if err := b.waitEvents(ctx, stream, state); err == nil {
+			return b.inspectState(ctx, id, state)
+		}
+		// the event stream dropped before we saw a die event;
+		// fall back to polling.
+	}
+
+	return b.waitPoll(ctx, id, state)
+}
+
+// containerTail emulates the `docker logs -f` command, streaming all
+// container logs until the container stops.
+func (b *dockerBackend) containerTail(ctx context.Context, id string, output io.Writer) error {
+	opts := types.ContainerLogsOptions{
+		Follow:     true,
+		ShowStdout: true,
+		ShowStderr: true,
+		Details:    false,
+		Timestamps: false,
+	}
+
+	logs, err := b.client.ContainerLogs(ctx, id, opts)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		stdcopy.StdCopy(output, output, logs)
+		logs.Close()
+	}()
+	return nil
+}
+
+func (b *dockerBackend) containerKill(ctx context.Context, id string) {
+	// Destroy kills every step unconditionally, including ones that
+	// never reached containerWait (e.g. a step that failed between
+	// containerStart and containerWait); release any event
+	// subscription still tracked for it so we don't leak the
+	// connection.
+	if stream := b.takeEventStream(id); stream != nil {
+		stream.cancel()
+	}
+	b.client.ContainerKill(ctx, id, "9")
+}
+
+func (b *dockerBackend) containerRemove(ctx context.Context, id string) {
+	b.client.ContainerRemove(ctx, id, types.ContainerRemoveOptions{
+		Force:         true,
+		RemoveLinks:   false,
+		RemoveVolumes: true,
+	})
+}
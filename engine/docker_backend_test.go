@@ -0,0 +1,62 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"docker.io/go-docker/api/types"
+	"docker.io/go-docker/api/types/volume"
+)
+
+func TestDockerBackendVolumeCreateUnknownDriver(t *testing.T) {
+	daemonErr := errors.New("Error response from daemon: create ci-cache: VolumeDriver.Create: error looking up volume plugin rexray: plugin not found")
+
+	cli := &fakeClient{
+		volumeCreateFunc: func(ctx context.Context, opts volume.VolumesCreateBody) (types.Volume, error) {
+			if opts.Driver != "rexray" {
+				t.Fatalf("expected the requested driver %q to be passed through, got %q", "rexray", opts.Driver)
+			}
+			if opts.DriverOpts["size"] != "10" {
+				t.Fatalf("expected driver opts to be passed through, got %v", opts.DriverOpts)
+			}
+			return types.Volume{}, daemonErr
+		},
+	}
+
+	b := &dockerBackend{client: cli}
+	vol := &Volume{
+		EmptyDir: &VolumeEmptyDir{
+			ID:         "ci-cache",
+			Driver:     "rexray",
+			DriverOpts: map[string]string{"size": "10"},
+		},
+	}
+
+	err := b.volumeCreate(context.Background(), vol)
+	if err != daemonErr {
+		t.Fatalf("expected the daemon's plugin-not-found error to surface unchanged, got %v", err)
+	}
+}
+
+func TestDockerBackendVolumeCreateDefaultsToLocal(t *testing.T) {
+	cli := &fakeClient{
+		volumeCreateFunc: func(ctx context.Context, opts volume.VolumesCreateBody) (types.Volume, error) {
+			if opts.Driver != "local" {
+				t.Fatalf("expected the default driver to be %q, got %q", "local", opts.Driver)
+			}
+			return types.Volume{}, nil
+		},
+	}
+
+	b := &dockerBackend{client: cli}
+	vol := &Volume{EmptyDir: &VolumeEmptyDir{ID: "ci-cache"}}
+
+	if err := b.volumeCreate(context.Background(), vol); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"docker.io/go-docker/api/types"
+	"docker.io/go-docker/api/types/events"
+	"docker.io/go-docker/api/types/filters"
+)
+
+// errEventStreamClosed is returned by waitEvents when the events
+// channel closes before a die event is observed, signalling the
+// caller to fall back to polling.
+var errEventStreamClosed = errors.New("engine: container events stream closed")
+
+// eventStream is the container-events subscription opened in
+// containerStart and consumed by containerWait.
+type eventStream struct {
+	messages <-chan events.Message
+	errs     <-chan error
+	cancel   context.CancelFunc
+}
+
+// subscribeEvents opens a container-events stream for id and stashes
+// it so containerWait can pick it up. Subscribing here, before
+// ContainerStart returns, means we cannot miss a die/oom/health event
+// that fires in the window between start and wait.
+func (b *dockerBackend) subscribeEvents(id string) {
+	evtCtx, cancel := context.WithCancel(context.Background())
+	messages, errs := b.client.Events(evtCtx, types.EventsOptions{
+		Filters: filters.NewArgs(
+			filters.Arg("container", id),
+			filters.Arg("type", "container"),
+		),
+	})
+
+	b.mu.Lock()
+	if b.events == nil {
+		b.events = map[string]*eventStream{}
+	}
+	b.events[id] = &eventStream{messages: messages, errs: errs, cancel: cancel}
+	b.mu.Unlock()
+}
+
+// takeEventStream returns and forgets the event stream opened for id,
+// or nil if none was opened (e.g. containerStart was never called).
+func (b *dockerBackend) takeEventStream(id string) *eventStream {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stream := b.events[id]
+	delete(b.events, id)
+	return stream
+}
+
+// waitEvents drives state off stream until a die event is observed,
+// returning nil once the container has exited. It returns an error if
+// the stream closes or errors first, so the caller can fall back to
+// polling.
+func (b *dockerBackend) waitEvents(ctx context.Context, stream *eventStream, state *State) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case err, ok := <-stream.errs:
+			if !ok {
+				// the errs channel closing on its own is not a die
+				// event; fall back to polling rather than reporting
+				// the container exited.
+				return errEventStreamClosed
+			}
+			if err != nil {
+				return err
+			}
+
+		case msg, ok := <-stream.messages:
+			if !ok {
+				return errEventStreamClosed
+			}
+			switch msg.Action {
+			case "die":
+				return nil
+			case "oom":
+				state.OOMKilled = true
+			default:
+				if isHealthStatusUnhealthy(msg.Action) {
+					state.HealthcheckFailed = true
+				}
+			}
+		}
+	}
+}
+
+// isHealthStatusUnhealthy reports whether a "health_status: <status>"
+// event action reports the container as unhealthy. Docker also emits
+// "health_status: starting" for every healthchecked container before
+// its first probe completes, so that (and any other non-"unhealthy"
+// status) must not be treated as a failure.
+func isHealthStatusUnhealthy(action string) bool {
+	return action == "health_status: unhealthy"
+}
+
+// waitPoll polls ContainerInspect with backoff until the container
+// stops being reported as running. It is the fallback used when the
+// events stream in waitEvents drops or was never available.
+func (b *dockerBackend) waitPoll(ctx context.Context, id string, state *State) (*State, error) {
+	backoff := time.Second
+	const maxBackoff = 15 * time.Second
+
+	for {
+		info, err := b.client.ContainerInspect(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if !info.State.Running {
+			return applyInspect(state, info)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// inspectState fetches the container's final exit code once
+// waitEvents has observed a die event.
+func (b *dockerBackend) inspectState(ctx context.Context, id string, state *State) (*State, error) {
+	info, err := b.client.ContainerInspect(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return applyInspect(state, info)
+}
+
+// applyInspect fills in the exit code and OOM flag from a container
+// inspection, preserving whatever waitEvents already observed.
+func applyInspect(state *State, info types.ContainerJSON) (*State, error) {
+	state.Exited = true
+	state.ExitCode = info.State.ExitCode
+	state.OOMKilled = state.OOMKilled || info.State.OOMKilled
+	return state, nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"testing"
+
+	"docker.io/go-docker/api/types/events"
+)
+
+func TestWaitEventsHealthStartingIsNotFailure(t *testing.T) {
+	messages := make(chan events.Message, 4)
+	messages <- events.Message{Action: "health_status: starting"}
+	messages <- events.Message{Action: "die"}
+	close(messages)
+
+	stream := &eventStream{messages: messages, errs: make(chan error), cancel: func() {}}
+
+	b := &dockerBackend{}
+	state := &State{}
+	if err := b.waitEvents(context.Background(), stream, state); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if state.HealthcheckFailed {
+		t.Fatal("health_status: starting must not be reported as a healthcheck failure")
+	}
+}
+
+func TestWaitEventsHealthUnhealthyIsFailure(t *testing.T) {
+	messages := make(chan events.Message, 4)
+	messages <- events.Message{Action: "health_status: unhealthy"}
+	messages <- events.Message{Action: "die"}
+	close(messages)
+
+	stream := &eventStream{messages: messages, errs: make(chan error), cancel: func() {}}
+
+	b := &dockerBackend{}
+	state := &State{}
+	if err := b.waitEvents(context.Background(), stream, state); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !state.HealthcheckFailed {
+		t.Fatal("expected health_status: unhealthy to be reported as a healthcheck failure")
+	}
+}
+
+func TestWaitEventsOOM(t *testing.T) {
+	messages := make(chan events.Message, 4)
+	messages <- events.Message{Action: "oom"}
+	messages <- events.Message{Action: "die"}
+	close(messages)
+
+	stream := &eventStream{messages: messages, errs: make(chan error), cancel: func() {}}
+
+	b := &dockerBackend{}
+	state := &State{}
+	if err := b.waitEvents(context.Background(), stream, state); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !state.OOMKilled {
+		t.Fatal("expected an oom event to set State.OOMKilled")
+	}
+}
+
+func TestWaitEventsErrsChannelCloseFallsBackToPolling(t *testing.T) {
+	messages := make(chan events.Message)
+	errs := make(chan error)
+	close(errs)
+
+	stream := &eventStream{messages: messages, errs: errs, cancel: func() {}}
+
+	b := &dockerBackend{}
+	state := &State{}
+	err := b.waitEvents(context.Background(), stream, state)
+	if err != errEventStreamClosed {
+		t.Fatalf("expected the errs channel closing to signal a fallback to polling, got %v", err)
+	}
+	if state.Exited {
+		t.Fatal("an events-stream teardown must not be reported as the container having exited")
+	}
+}
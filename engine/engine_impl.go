@@ -7,32 +7,38 @@ package engine
 import (
 	"context"
 	"io"
-	"io/ioutil"
-
-	"github.com/drone-runners/drone-runner-docker/engine/stdcopy"
-	"github.com/drone/drone-runtime/engine/docker/auth"
 
 	"docker.io/go-docker"
-	"docker.io/go-docker/api/types"
-	"docker.io/go-docker/api/types/volume"
+
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/docker/docker/pkg/term"
 )
 
 type engine struct {
-	client docker.APIClient
+	backend backend
+	puller  *pullCoordinator
 }
 
-// New returns a new engine.
+// New returns a new Docker-backed engine.
 func New(client docker.APIClient) Engine {
-	return &engine{client}
+	return &engine{
+		backend: &dockerBackend{client: client},
+		puller:  newPullCoordinatorFromEnv(),
+	}
 }
 
-// NewEnv returns a new Engine from the environment.
+// NewEnv returns a new Engine from the environment, selecting between
+// the Docker and Podman backends based on DRONE_RUNNER_BACKEND (or
+// auto-detection when it is unset).
 func NewEnv() (Engine, error) {
-	cli, err := docker.NewEnvClient()
+	b, err := newBackend()
 	if err != nil {
 		return nil, err
 	}
-	return New(cli), nil
+	return &engine{
+		backend: b,
+		puller:  newPullCoordinatorFromEnv(),
+	}, nil
 }
 
 // Setup the pipeline environment.
@@ -43,46 +49,26 @@ func (e *engine) Setup(ctx context.Context, spec *Spec) error {
 		if vol.EmptyDir == nil {
 			continue
 		}
-		_, err := e.client.VolumeCreate(ctx, volume.VolumesCreateBody{
-			Name:   vol.EmptyDir.ID,
-			Driver: "local",
-			Labels: vol.EmptyDir.Labels,
-		})
-		if err != nil {
+		if err := e.backend.volumeCreate(ctx, vol); err != nil {
 			return err
 		}
 	}
 
 	// creates the default pod network. All containers
 	// defined in the pipeline are attached to this network.
-	driver := "bridge"
-	if spec.Platform.OS == "windows" {
-		driver = "nat"
-	}
-	_, err := e.client.NetworkCreate(ctx, spec.Network.ID, types.NetworkCreate{
-		Driver: driver,
-		Labels: spec.Network.Labels,
-	})
-
-	return err
+	return e.backend.networkCreate(ctx, spec)
 }
 
 // Destroy the pipeline environment.
 func (e *engine) Destroy(ctx context.Context, spec *Spec) error {
-	removeOpts := types.ContainerRemoveOptions{
-		Force:         true,
-		RemoveLinks:   false,
-		RemoveVolumes: true,
-	}
-
 	// stop all containers
 	for _, step := range spec.Steps {
-		e.client.ContainerKill(ctx, step.ID, "9")
+		e.backend.containerKill(ctx, step.ID)
 	}
 
 	// cleanup all containers
 	for _, step := range spec.Steps {
-		e.client.ContainerRemove(ctx, step.ID, removeOpts)
+		e.backend.containerRemove(ctx, step.ID)
 	}
 
 	// cleanup all volumes
@@ -95,11 +81,11 @@ func (e *engine) Destroy(ctx context.Context, spec *Spec) error {
 		if vol.EmptyDir.Medium == "memory" {
 			continue
 		}
-		e.client.VolumeRemove(ctx, vol.EmptyDir.ID, true)
+		e.backend.volumeRemove(ctx, vol)
 	}
 
 	// cleanup the network
-	e.client.NetworkRemove(ctx, spec.Network.ID)
+	e.backend.networkRemove(ctx, spec)
 
 	// notice that we never collect or return any errors.
 	// this is because we silently ignore cleanup failures
@@ -116,17 +102,17 @@ func (e *engine) Run(ctx context.Context, spec *Spec, step *Step, output io.Writ
 		return nil, err
 	}
 	// start the container
-	err = e.start(ctx, step.ID)
+	err = e.backend.containerStart(ctx, step.ID)
 	if err != nil {
 		return nil, err
 	}
 	// tail the container
-	err = e.tail(ctx, step.ID, output)
+	err = e.backend.containerTail(ctx, step.ID, output)
 	if err != nil {
 		return nil, err
 	}
 	// wait for the response
-	return e.wait(ctx, step.ID)
+	return e.backend.containerWait(ctx, step.ID)
 }
 
 //
@@ -142,124 +128,54 @@ func (e *engine) create(ctx context.Context, spec *Spec, step *Step, output io.W
 		return err
 	}
 
-	// create pull options with encoded authorization credentials.
-	pullopts := types.ImagePullOptions{}
-	if step.Auth != nil {
-		pullopts.RegistryAuth = auth.Encode(
-			step.Auth.Username,
-			step.Auth.Password,
-		)
-	}
-
 	// automatically pull the latest version of the image if requested
 	// by the process configuration, or if the image is :latest
 	if step.Pull == PullAlways ||
 		(step.Pull == PullDefault && latest) {
-		rc, pullerr := e.client.ImagePull(ctx, step.Image, pullopts)
-		if pullerr == nil {
-			io.Copy(ioutil.Discard, rc)
-			rc.Close()
-		}
-		if pullerr != nil {
-			return pullerr
+		if err := e.pull(ctx, step, output); err != nil {
+			return err
 		}
 	}
 
-	_, err = e.client.ContainerCreate(ctx,
-		toConfig(spec, step),
-		toHostConfig(spec, step),
-		toNetConfig(spec, step),
-		step.ID,
-	)
+	err = e.backend.containerCreate(ctx, spec, step)
 
 	// automatically pull and try to re-create the image if the
 	// failure is caused because the image does not exist.
-	if docker.IsErrImageNotFound(err) && step.Pull != PullNever {
-		rc, pullerr := e.client.ImagePull(ctx, step.Image, pullopts)
-		if pullerr != nil {
-			return pullerr
+	if e.backend.imagePullErrNotFound(err) && step.Pull != PullNever {
+		if err := e.pull(ctx, step, output); err != nil {
+			return err
 		}
-		io.Copy(ioutil.Discard, rc)
-		rc.Close()
 
 		// once the image is successfully pulled we attempt to
 		// re-create the container.
-		_, err = e.client.ContainerCreate(ctx,
-			toConfig(spec, step),
-			toHostConfig(spec, step),
-			toNetConfig(spec, step),
-			step.ID,
-		)
+		err = e.backend.containerCreate(ctx, spec, step)
 	}
-	if err != nil {
-		return err
-	}
-
-	// // use the default user-defined network if network_mode
-	// // is not otherwise specified.
-	// if step.Network == "" {
-	// 	for _, net := range step.Networks {
-	// 		err = e.client.NetworkConnect(ctx, net, step.ID, &network.EndpointSettings{
-	// 			Aliases: []string{net},
-	// 		})
-	// 		if err != nil {
-	// 			return nil
-	// 		}
-	// 	}
-	// }
 
-	return nil
+	return err
 }
 
-// helper function emulates the `docker start` command.
-func (e *engine) start(ctx context.Context, id string) error {
-	return e.client.ContainerStart(ctx, id, types.ContainerStartOptions{})
+// pull pulls the step image and streams its progress to output. Pulls
+// of the same image (by image+registryAuth) are deduplicated and
+// rate-limited across concurrently running steps by e.puller, so a
+// matrix build with many steps pulling the same image at the same time
+// issues exactly one ImagePull call.
+func (e *engine) pull(ctx context.Context, step *Step, output io.Writer) error {
+	return e.puller.do(ctx, pullKey(step), func(ctx context.Context) error {
+		rc, err := e.backend.imagePull(ctx, step)
+		if err != nil {
+			return err
+		}
+		err = e.displayPull(rc, output)
+		rc.Close()
+		return err
+	})
 }
 
-// helper function emulates the `docker wait` command, blocking
-// until the container stops and returning the exit code.
-func (e *engine) wait(ctx context.Context, id string) (*State, error) {
-	wait, errc := e.client.ContainerWait(ctx, id, "")
-	select {
-	case <-wait:
-	case <-errc:
-	}
-
-	info, err := e.client.ContainerInspect(ctx, id)
-	if err != nil {
-		return nil, err
-	}
-	if info.State.Running {
-		// TODO(bradrydewski) if the state is still running
-		// we should call wait again.
-	}
-
-	return &State{
-		Exited:    true,
-		ExitCode:  info.State.ExitCode,
-		OOMKilled: info.State.OOMKilled,
-	}, nil
+// displayPull decodes the docker JSON message stream returned by
+// imagePull and forwards progress to output as human-readable log
+// lines. Any errorDetail reported by the daemon is returned as an
+// error rather than being silently discarded.
+func (e *engine) displayPull(rc io.Reader, output io.Writer) error {
+	fd, isTerminal := term.GetFdInfo(output)
+	return jsonmessage.DisplayJSONMessagesStream(rc, output, fd, isTerminal, nil)
 }
-
-// helper function emulates the `docker logs -f` command, streaming
-// all container logs until the container stops.
-func (e *engine) tail(ctx context.Context, id string, output io.Writer) error {
-	opts := types.ContainerLogsOptions{
-		Follow:     true,
-		ShowStdout: true,
-		ShowStderr: true,
-		Details:    false,
-		Timestamps: false,
-	}
-
-	logs, err := e.client.ContainerLogs(ctx, id, opts)
-	if err != nil {
-		return err
-	}
-
-	go func() {
-		stdcopy.StdCopy(output, output, logs)
-		logs.Close()
-	}()
-	return nil
-}
\ No newline at end of file
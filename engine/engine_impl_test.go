@@ -0,0 +1,44 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestEngineDisplayPull(t *testing.T) {
+	stream := strings.NewReader(`
+{"status":"Pulling from library/golang","id":"1.22"}
+{"status":"Downloading","progressDetail":{"current":512,"total":1024},"id":"abc123"}
+{"status":"Pull complete","id":"abc123"}
+`)
+
+	e := &engine{}
+	var out bytes.Buffer
+	if err := e.displayPull(stream, &out); err != nil {
+		t.Fatalf("displayPull returned an error for a clean pull stream: %v", err)
+	}
+	if out.Len() == 0 {
+		t.Fatal("expected pull progress to be written to the step output")
+	}
+}
+
+func TestEngineDisplayPullErrorDetail(t *testing.T) {
+	stream := strings.NewReader(
+		`{"errorDetail":{"message":"manifest for golang:bogus not found"},"error":"manifest for golang:bogus not found"}` + "\n",
+	)
+
+	e := &engine{}
+	var out bytes.Buffer
+	err := e.displayPull(stream, &out)
+	if err == nil {
+		t.Fatal("expected an error when the pull stream reports an errorDetail")
+	}
+	if !strings.Contains(err.Error(), "manifest for golang:bogus not found") {
+		t.Fatalf("expected the daemon error message to surface, got: %v", err)
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"docker.io/go-docker"
+	"docker.io/go-docker/api/types"
+	"docker.io/go-docker/api/types/volume"
+)
+
+// fakeClient is a docker.APIClient test double. It embeds the
+// interface so a test only needs to override the handful of methods
+// it actually exercises; calling anything else panics on the nil
+// embedded interface, which is the signal a test needs to stub it.
+type fakeClient struct {
+	docker.APIClient
+
+	mu         sync.Mutex
+	imagePullN int
+
+	imagePullFunc    func(ctx context.Context, image string, opts types.ImagePullOptions) (io.ReadCloser, error)
+	volumeCreateFunc func(ctx context.Context, opts volume.VolumesCreateBody) (types.Volume, error)
+}
+
+func (f *fakeClient) ImagePull(ctx context.Context, image string, opts types.ImagePullOptions) (io.ReadCloser, error) {
+	f.mu.Lock()
+	f.imagePullN++
+	f.mu.Unlock()
+
+	if f.imagePullFunc != nil {
+		return f.imagePullFunc(ctx, image, opts)
+	}
+	return ioutil.NopCloser(strings.NewReader("")), nil
+}
+
+// pullCount returns the number of ImagePull calls observed so far.
+func (f *fakeClient) pullCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.imagePullN
+}
+
+func (f *fakeClient) VolumeCreate(ctx context.Context, opts volume.VolumesCreateBody) (types.Volume, error) {
+	if f.volumeCreateFunc != nil {
+		return f.volumeCreateFunc(ctx, opts)
+	}
+	return types.Volume{}, nil
+}
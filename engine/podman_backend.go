@@ -0,0 +1,87 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"strings"
+
+	"docker.io/go-docker"
+	"docker.io/go-docker/api/types/container"
+)
+
+// podmanBackend talks to a Podman REST service over its
+// Docker-compatible endpoint. It reuses dockerBackend for every
+// operation that behaves identically under Podman, and only
+// overrides the host config translation that Podman's rootless
+// execution model requires.
+type podmanBackend struct {
+	dockerBackend
+}
+
+// newPodmanBackend returns a backend that talks to the Podman REST
+// service listening on the unix socket at sock (e.g.
+// "/run/podman/podman.sock").
+func newPodmanBackend(sock string) (backend, error) {
+	cli, err := docker.NewClient("unix://"+sock, "", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &podmanBackend{dockerBackend{client: cli}}, nil
+}
+
+// containerCreate applies the Podman-specific host config translation
+// (":z" SELinux relabeling of bind mounts, and rootless UID/GID
+// mapping) that a real Docker daemon does not need, then delegates to
+// the shared createContainer so Podman containers get the same
+// extra-network attach behaviour as Docker ones.
+func (b *podmanBackend) containerCreate(ctx context.Context, spec *Spec, step *Step) error {
+	hostConfig := toHostConfig(spec, step)
+	relabelBinds(hostConfig)
+	if hostConfig.UsernsMode == "" {
+		// run the container inside Podman's default rootless
+		// UID/GID mapping rather than Docker's userns-remap.
+		hostConfig.UsernsMode = container.UsernsMode("keep-id")
+	}
+
+	return createContainer(ctx, b.client, spec, step, hostConfig)
+}
+
+// relabelBinds appends the "z" SELinux relabel option to every bind
+// mount that does not already request one, so containers can read and
+// write host paths under an SELinux-enforcing Podman install. A bind
+// spec is "host:container[:options]" with options comma-joined (e.g.
+// "ro,z"), so the option is added to that comma-joined list rather
+// than appended as a bare ":z" suffix, which would turn something
+// like "/host:/container:ro" into the invalid "/host:/container:ro:z".
+func relabelBinds(hc *container.HostConfig) {
+	for i, bind := range hc.Binds {
+		parts := strings.SplitN(bind, ":", 3)
+		if len(parts) < 2 {
+			continue
+		}
+
+		var opts []string
+		if len(parts) == 3 && parts[2] != "" {
+			opts = strings.Split(parts[2], ",")
+		}
+		if hasBindOption(opts, "z") || hasBindOption(opts, "Z") {
+			continue
+		}
+
+		opts = append(opts, "z")
+		hc.Binds[i] = parts[0] + ":" + parts[1] + ":" + strings.Join(opts, ",")
+	}
+}
+
+// hasBindOption reports whether opts already contains opt.
+func hasBindOption(opts []string, opt string) bool {
+	for _, o := range opts {
+		if o == opt {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"testing"
+
+	"docker.io/go-docker/api/types/container"
+)
+
+func TestRelabelBinds(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "no options",
+			in:   "/host:/container",
+			want: "/host:/container:z",
+		},
+		{
+			name: "existing option is preserved and comma-joined",
+			in:   "/host:/container:ro",
+			want: "/host:/container:ro,z",
+		},
+		{
+			name: "already relabelled with z is left alone",
+			in:   "/host:/container:z",
+			want: "/host:/container:z",
+		},
+		{
+			name: "already relabelled with Z is left alone",
+			in:   "/host:/container:ro,Z",
+			want: "/host:/container:ro,Z",
+		},
+		{
+			name: "multiple existing options are preserved",
+			in:   "/host:/container:ro,cached",
+			want: "/host:/container:ro,cached,z",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hc := &container.HostConfig{Binds: []string{test.in}}
+			relabelBinds(hc)
+			if got := hc.Binds[0]; got != test.want {
+				t.Errorf("relabelBinds(%q) = %q, want %q", test.in, got, test.want)
+			}
+		})
+	}
+}
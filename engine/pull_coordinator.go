@@ -0,0 +1,123 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// defaultPullRate and defaultPullBurst bound how many image pulls the
+// coordinator lets through per second, keeping a pipeline with many
+// steps and many distinct images from tripping a registry's
+// anonymous-pull rate limit. Override with DRONE_RUNNER_PULL_RATE /
+// DRONE_RUNNER_PULL_BURST for registries with different limits (e.g.
+// an authenticated self-hosted mirror).
+const (
+	defaultPullRate  = 3
+	defaultPullBurst = 5
+)
+
+// pullCoordinator deduplicates concurrent pulls of the same image and
+// rate-limits the pulls that do go out to the registry. Steps that
+// pull the same image at the same time (a matrix build all using
+// golang:1.22, for example) cause exactly one ImagePull call instead
+// of one per step; a step that pulls after that call has finished
+// issues a fresh one.
+type pullCoordinator struct {
+	mu       sync.Mutex
+	inFlight map[string]*pullOnce
+	limiter  *rate.Limiter
+}
+
+// pullOnce guards a single in-flight (or completed) pull.
+type pullOnce struct {
+	once sync.Once
+	err  error
+}
+
+// newPullCoordinator returns a coordinator rate-limited to pullsPerSec
+// pulls per second with the given burst.
+func newPullCoordinator(pullsPerSec float64, burst int) *pullCoordinator {
+	return &pullCoordinator{
+		inFlight: map[string]*pullOnce{},
+		limiter:  rate.NewLimiter(rate.Limit(pullsPerSec), burst),
+	}
+}
+
+// newPullCoordinatorFromEnv returns a coordinator rate-limited
+// according to DRONE_RUNNER_PULL_RATE / DRONE_RUNNER_PULL_BURST,
+// falling back to defaultPullRate / defaultPullBurst for any unset or
+// invalid value.
+func newPullCoordinatorFromEnv() *pullCoordinator {
+	return newPullCoordinator(
+		pullFloatEnv("DRONE_RUNNER_PULL_RATE", defaultPullRate),
+		pullIntEnv("DRONE_RUNNER_PULL_BURST", defaultPullBurst),
+	)
+}
+
+func pullFloatEnv(name string, fallback float64) float64 {
+	v, err := strconv.ParseFloat(os.Getenv(name), 64)
+	if err != nil {
+		return fallback
+	}
+	return v
+}
+
+func pullIntEnv(name string, fallback int) int {
+	v, err := strconv.Atoi(os.Getenv(name))
+	if err != nil {
+		return fallback
+	}
+	return v
+}
+
+// pullKey returns the dedup key for a step's image pull: the image
+// name plus any registry credentials, so two steps pulling the same
+// image under different credentials are not incorrectly coalesced.
+func pullKey(step *Step) string {
+	key := step.Image
+	if step.Auth != nil {
+		key += "|" + step.Auth.Username + "|" + step.Auth.Password
+	}
+	return key
+}
+
+// do runs pull for key at most once no matter how many goroutines
+// call do concurrently with the same key; all of them block until the
+// one call completes and share its result. The coordinator only
+// dedupes pulls that are genuinely in flight together: the entry is
+// always evicted once the pull settles, win or lose, so it never
+// serves a stale cached result to a later, unrelated call for the
+// same image over the engine's lifetime.
+func (c *pullCoordinator) do(ctx context.Context, key string, pull func(ctx context.Context) error) error {
+	c.mu.Lock()
+	p, ok := c.inFlight[key]
+	if !ok {
+		p = &pullOnce{}
+		c.inFlight[key] = p
+	}
+	c.mu.Unlock()
+
+	p.once.Do(func() {
+		if err := c.limiter.Wait(ctx); err != nil {
+			p.err = err
+		} else {
+			p.err = pull(ctx)
+		}
+
+		c.mu.Lock()
+		if c.inFlight[key] == p {
+			delete(c.inFlight, key)
+		}
+		c.mu.Unlock()
+	})
+
+	return p.err
+}
@@ -0,0 +1,149 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"docker.io/go-docker/api/types"
+)
+
+func TestNewPullCoordinatorFromEnv(t *testing.T) {
+	t.Setenv("DRONE_RUNNER_PULL_RATE", "7.5")
+	t.Setenv("DRONE_RUNNER_PULL_BURST", "9")
+
+	c := newPullCoordinatorFromEnv()
+	if got := float64(c.limiter.Limit()); got != 7.5 {
+		t.Errorf("expected DRONE_RUNNER_PULL_RATE to set the limiter rate, got %v", got)
+	}
+	if got := c.limiter.Burst(); got != 9 {
+		t.Errorf("expected DRONE_RUNNER_PULL_BURST to set the limiter burst, got %v", got)
+	}
+}
+
+func TestNewPullCoordinatorFromEnvDefaults(t *testing.T) {
+	t.Setenv("DRONE_RUNNER_PULL_RATE", "")
+	t.Setenv("DRONE_RUNNER_PULL_BURST", "not-a-number")
+
+	c := newPullCoordinatorFromEnv()
+	if got := float64(c.limiter.Limit()); got != defaultPullRate {
+		t.Errorf("expected an unset rate to fall back to the default, got %v", got)
+	}
+	if got := c.limiter.Burst(); got != defaultPullBurst {
+		t.Errorf("expected an invalid burst to fall back to the default, got %v", got)
+	}
+}
+
+// TestEnginePullDedupesConcurrentSteps mirrors a matrix build where
+// many steps share the same image: they must cause exactly one
+// ImagePull call against the daemon.
+func TestEnginePullDedupesConcurrentSteps(t *testing.T) {
+	const steps = 10
+
+	// hold the pull open until every step has started pulling, so the
+	// steps are genuinely in flight together.
+	var ready sync.WaitGroup
+	ready.Add(steps)
+	cli := &fakeClient{
+		imagePullFunc: func(ctx context.Context, image string, opts types.ImagePullOptions) (io.ReadCloser, error) {
+			ready.Wait()
+			time.Sleep(10 * time.Millisecond)
+			return ioutil.NopCloser(strings.NewReader("")), nil
+		},
+	}
+	e := &engine{
+		backend: &dockerBackend{client: cli},
+		puller:  newPullCoordinator(defaultPullRate, defaultPullBurst),
+	}
+
+	var wg sync.WaitGroup
+	var out bytes.Buffer
+	for i := 0; i < steps; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ready.Done()
+			step := &Step{ID: fmt.Sprintf("step-%d", i), Image: "golang:1.22"}
+			if err := e.pull(context.Background(), step, &out); err != nil {
+				t.Errorf("unexpected pull error: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if got := cli.pullCount(); got != 1 {
+		t.Fatalf("expected %d steps sharing an image to cause exactly 1 ImagePull call, got %d", steps, got)
+	}
+}
+
+// TestPullCoordinatorEvictsAfterCompletion guards against the
+// coordinator permanently caching a pull result for the lifetime of
+// the engine: a later, unrelated call for the same key must trigger a
+// fresh pull rather than replaying a stale result.
+func TestPullCoordinatorEvictsAfterCompletion(t *testing.T) {
+	c := newPullCoordinator(defaultPullRate, defaultPullBurst)
+	var calls int32
+	pull := func(ctx context.Context) error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	}
+
+	if err := c.do(context.Background(), "golang:1.22", pull); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := c.do(context.Background(), "golang:1.22", pull); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Fatalf("expected a later call for the same image to trigger a fresh pull instead of a cached result, got %d calls", got)
+	}
+}
+
+// TestPullCoordinatorConcurrentCallsShareOneInFlightPull verifies the
+// dedup half of the contract in isolation from eviction: callers that
+// overlap in time must share a single pull.
+func TestPullCoordinatorConcurrentCallsShareOneInFlightPull(t *testing.T) {
+	c := newPullCoordinator(defaultPullRate, defaultPullBurst)
+	var calls int32
+
+	const callers = 20
+
+	// hold the pull open until every caller has started, so the calls
+	// genuinely overlap in time.
+	var ready sync.WaitGroup
+	ready.Add(callers)
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ready.Done()
+			err := c.do(context.Background(), "golang:1.22", func(ctx context.Context) error {
+				atomic.AddInt32(&calls, 1)
+				ready.Wait()
+				time.Sleep(10 * time.Millisecond)
+				return nil
+			})
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("expected %d concurrent callers sharing an image to cause exactly 1 pull, got %d", callers, got)
+	}
+}